@@ -0,0 +1,200 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"flag"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// walkName is the key under which walkConfig is stored in
+// config.Config.Exts.
+const walkName = "_walk"
+
+// walkConfig holds the state accumulated from "gazelle:exclude",
+// "gazelle:follow", "gazelle:ignore", "gazelle:respect_gitignore",
+// "gazelle:respect_bazelignore" and "gazelle:strict_directives"
+// directives as Walk descends through the repository.
+type walkConfig struct {
+	excludes []string
+	follow   []string
+	ignore   bool
+
+	respectGitignore   bool
+	respectBazelignore bool
+	gitignorePatterns  []gitignorePattern
+
+	strictDirectives bool
+}
+
+func getWalkConfig(c *config.Config) *walkConfig {
+	return c.Exts[walkName].(*walkConfig)
+}
+
+// walkCacheName is the key under which the persistent walk cache is
+// stored in config.Config.Exts.
+const walkCacheName = "_walk_cache"
+
+func getWalkCache(c *config.Config) *walkCache {
+	wc, _ := c.Exts[walkCacheName].(*walkCache)
+	return wc
+}
+
+// Configurer is a config.Configurer that tracks "gazelle:exclude",
+// "gazelle:follow", "gazelle:ignore", "gazelle:respect_gitignore",
+// "gazelle:respect_bazelignore" and "gazelle:strict_directives"
+// directives for the walk package, and loads the -walk_cache file used
+// by Walk and WalkConcurrent.
+type Configurer struct {
+	cacheFile       string
+	cacheDisableFor stringsFlag
+}
+
+func (cext *Configurer) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
+	c.Exts[walkName] = &walkConfig{respectGitignore: true, respectBazelignore: true}
+	fs.StringVar(&cext.cacheFile, "walk_cache", "", "path to a file used to cache the results of walking the repository between runs; empty disables caching")
+	fs.Var(&cext.cacheDisableFor, "walk_cache_disable_for", "repository-relative directory (may be repeated) that should always be re-scanned, bypassing -walk_cache")
+}
+
+func (cext *Configurer) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
+	cache, err := loadWalkCache(cext.cacheFile, cext.cacheDisableFor)
+	if err != nil {
+		return err
+	}
+	c.Exts[walkCacheName] = cache
+	return nil
+}
+
+func (*Configurer) KnownDirectives() []string {
+	return []string{"exclude", "follow", "ignore", "respect_gitignore", "respect_bazelignore", "strict_directives"}
+}
+
+func (*Configurer) Configure(c *config.Config, rel string, f *rule.File) {
+	parent := getWalkConfig(c)
+	wc := &walkConfig{
+		excludes:           append([]string(nil), parent.excludes...),
+		follow:             append([]string(nil), parent.follow...),
+		respectGitignore:   parent.respectGitignore,
+		respectBazelignore: parent.respectBazelignore,
+		gitignorePatterns:  append([]gitignorePattern(nil), parent.gitignorePatterns...),
+		strictDirectives:   parent.strictDirectives,
+	}
+	c.Exts[walkName] = wc
+
+	if f == nil {
+		return
+	}
+	for _, d := range f.Directives {
+		switch d.Key {
+		case "exclude":
+			wc.excludes = append(wc.excludes, path.Join(rel, d.Value))
+		case "follow":
+			wc.follow = append(wc.follow, path.Join(rel, d.Value))
+		case "ignore":
+			wc.ignore = true
+		case "respect_gitignore":
+			wc.respectGitignore = d.Value != "false"
+		case "respect_bazelignore":
+			wc.respectBazelignore = d.Value != "false"
+		case "strict_directives":
+			wc.strictDirectives = d.Value != "false"
+		}
+	}
+}
+
+// stringsFlag accumulates repeated occurrences of a flag into a slice.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// isExcluded reports whether candidate (a slash-separated path relative
+// to the repository root, which is a directory iff isDir) is excluded
+// by a "gazelle:exclude" directive, a .bazelignore entry, or a
+// .gitignore pattern.
+func (w *walker) isExcluded(wc *walkConfig, candidate string, isDir bool) bool {
+	if matchesAny(wc.excludes, candidate) {
+		return true
+	}
+	if wc.respectBazelignore && matchesBazelignore(w.bazelignore, candidate) {
+		return true
+	}
+	if wc.respectGitignore && matchesGitignore(wc.gitignorePatterns, candidate, isDir) {
+		return true
+	}
+	return false
+}
+
+func matchesAny(patterns []string, candidate string) bool {
+	for _, p := range patterns {
+		if globMatch(p, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch reports whether name matches pattern, where pattern may
+// contain "*" (matching any run of characters within a single path
+// segment, as in filepath.Match) and "**" (matching any number of whole
+// path segments, including zero).
+func globMatch(pattern, name string) bool {
+	if pattern == name {
+		return true
+	}
+	return matchSegments(splitPath(pattern), splitPath(name))
+}
+
+func splitPath(p string) []string {
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		if matchSegments(pattern[1:], name) {
+			return true
+		}
+		if len(name) == 0 {
+			return false
+		}
+		return matchSegments(pattern, name[1:])
+	}
+	if len(name) == 0 {
+		return false
+	}
+	ok, err := filepath.Match(pattern[0], name[0])
+	if err != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], name[1:])
+}
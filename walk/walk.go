@@ -0,0 +1,734 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package walk provides customizable functionality for visiting
+// each subdirectory in a directory tree, with a build file in a
+// directory acting as a boundary for configuration inheritance.
+package walk
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// WalkFunc is a callback called by Walk for each visited directory.
+//
+// dir is the absolute file system path to the directory.
+//
+// rel is the relative slash-separated path to the directory from the
+// repository root.
+//
+// c is the configuration for the current directory. This may have been
+// modified by directives in the build file.
+//
+// update is true when the build file may be updated.
+//
+// f is the existing build file in the directory. Nil if there was no
+// existing build file.
+//
+// subdirs is a list of base names of subdirectories within dir, not
+// including excluded files or directories.
+//
+// regularFiles is a list of base names of regular files within dir, not
+// including excluded files or symlinks.
+//
+// genFiles is a list of names of generated files, found by reading
+// "out" and "outs" attributes of rules in f.
+type WalkFunc func(dir, rel string, c *config.Config, update bool, f *rule.File, subdirs, regularFiles, genFiles []string)
+
+// Mode determines which directories Walk visits and which directories
+// it updates, i.e., creates or merges build files for.
+type Mode int
+
+const (
+	// VisitAllUpdateSubdirsMode causes Walk to visit every directory in the
+	// repository. The directories given to Walk and all of their
+	// subdirectories are updated.
+	VisitAllUpdateSubdirsMode Mode = iota
+
+	// VisitAllUpdateDirsMode causes Walk to visit every directory in the
+	// repository. Only the directories given to Walk are updated (not
+	// their subdirectories).
+	VisitAllUpdateDirsMode
+
+	// UpdateDirsMode causes Walk to only visit the directories given to
+	// Walk. Only these directories are updated.
+	UpdateDirsMode
+
+	// UpdateSubdirsMode causes Walk to only visit the directories given to
+	// Walk and their subdirectories. All of these directories are updated.
+	UpdateSubdirsMode
+)
+
+// WalkAction tells WalkEx how to proceed after its pre-order callback
+// returns for a directory.
+type WalkAction int
+
+const (
+	// Continue recurses into the directory's subdirectories as usual
+	// (subject to Mode) and delivers the post-order callback for it once
+	// they've been visited.
+	Continue WalkAction = iota
+
+	// SkipDir prevents Walk from descending into this directory's
+	// subdirectories. The directory's own post-order callback is still
+	// delivered, and unrelated siblings continue to be visited normally.
+	SkipDir
+
+	// Stop halts the walk entirely: no further directories are visited
+	// or descended into. Directories already on the call stack still
+	// receive their post-order callback as the recursion unwinds, so
+	// Configure state is torn down consistently.
+	Stop
+)
+
+// WalkFuncEx is a pre-order callback for WalkEx, invoked for a directory
+// before Walk decides whether to descend into it. Its return value
+// controls that decision; see WalkAction.
+type WalkFuncEx func(dir, rel string, c *config.Config, f *rule.File) WalkAction
+
+// Walk walks the file tree rooted at the repository root. It visits
+// every directory that can reach one of dirs, as well as every
+// descendant required by mode, and calls f for each directory that
+// should be visited according to mode.
+//
+// Configure is called on every Configurer in cexts for every directory
+// visited or traversed, in pre-order, so that each directory's
+// configuration is derived from its parent's. f is called in post-order,
+// once all of a directory's children have been visited.
+//
+// Walk returns a non-nil error if a directory has "# gazelle:
+// strict_directives true" in effect and an unrecognized "# gazelle:"
+// directive is encountered within it; the walk halts at that point, but
+// directories already descended into still receive their post-order
+// callback as the recursion unwinds.
+func Walk(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode, f WalkFunc) error {
+	return WalkEx(c, cexts, dirs, mode, nil, f)
+}
+
+// WalkEx is like Walk, but also invokes pre, pre-order, for every
+// directory before Walk decides whether to descend into it, letting
+// callers prune subtrees or stop the walk early at callback time instead
+// of baking every decision into Mode and "gazelle:exclude" up front; see
+// WalkAction. A nil pre makes WalkEx behave exactly like Walk.
+func WalkEx(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode, pre WalkFuncEx, post WalkFunc) error {
+	targets := targetRelSet(c.RepoRoot, dirs)
+	bazelignore, err := loadBazelignore(c.RepoRoot)
+	if err != nil {
+		log.Print(err)
+	}
+	w := &walker{
+		c:               c,
+		cexts:           cexts,
+		targets:         targets,
+		visitAll:        mode == VisitAllUpdateSubdirsMode || mode == VisitAllUpdateDirsMode,
+		updateSubdirs:   mode == VisitAllUpdateSubdirsMode || mode == UpdateSubdirsMode,
+		visitedReal:     make(map[string]bool),
+		cache:           getWalkCache(c),
+		mode:            mode,
+		f:               post,
+		preFunc:         pre,
+		bazelignore:     bazelignore,
+		knownDirectives: unionKnownDirectives(cexts),
+		warned:          make(map[string]bool),
+	}
+	w.visit(c, c.RepoRoot, "")
+	if err := w.cache.save(); err != nil {
+		log.Print(err)
+	}
+	w.cache.logStats()
+	return w.strictErr()
+}
+
+// walker carries the state needed throughout a single Walk call.
+type walker struct {
+	c             *config.Config
+	cexts         []config.Configurer
+	targets       map[string]bool
+	visitAll      bool
+	updateSubdirs bool
+	visitedReal   map[string]bool
+	cache         *walkCache
+	mode          Mode
+	f             WalkFunc
+	preFunc       WalkFuncEx // optional pre-order callback passed to WalkEx; nil for Walk
+	stopped       int32      // set by stop() once a WalkFuncEx has returned Stop
+	bazelignore   []string   // patterns loaded once from .bazelignore at the repository root
+
+	knownDirectives map[string]bool // union of KnownDirectives() across cexts
+	warnedMu        sync.Mutex
+	warned          map[string]bool // (rel, directive) pairs already reported this run
+
+	errMu sync.Mutex
+	err   error // first strict_directives violation encountered, if any
+}
+
+// isStopped reports whether a WalkFuncEx has already returned Stop, so
+// no further directories should be visited.
+func (w *walker) isStopped() bool {
+	return atomic.LoadInt32(&w.stopped) != 0
+}
+
+// stop records that the walk must not visit any further directories.
+func (w *walker) stop() {
+	atomic.StoreInt32(&w.stopped, 1)
+}
+
+// setStrictErr records err as the reason the walk is halting, if no
+// other error was already recorded, and stops the walk the same way a
+// WalkFuncEx returning Stop would: directories already descended into
+// still receive their post-order callback as the recursion unwinds, and
+// the cache is still saved before Walk returns the error.
+func (w *walker) setStrictErr(err error) {
+	w.errMu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.errMu.Unlock()
+	w.stop()
+}
+
+// strictErr returns the first strict_directives violation recorded by
+// setStrictErr, or nil if the walk completed without one.
+func (w *walker) strictErr() error {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	return w.err
+}
+
+// targetRelSet converts the absolute directories passed to Walk into a
+// set of slash-separated paths relative to root.
+func targetRelSet(root string, dirs []string) map[string]bool {
+	targets := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+		targets[rel] = true
+	}
+	return targets
+}
+
+// isAncestorOfTarget reports whether rel is an ancestor of (or equal to)
+// one of the directories passed to Walk, i.e., whether Walk must
+// traverse through rel to reach a target.
+func (w *walker) isAncestorOfTarget(rel string) bool {
+	if rel == "" {
+		return true
+	}
+	for t := range w.targets {
+		if t == rel || strings.HasPrefix(t, rel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// inTargetSubtree reports whether rel is one of the directories passed
+// to Walk, or a descendant of one of them.
+func (w *walker) inTargetSubtree(rel string) bool {
+	if w.targets[rel] {
+		return true
+	}
+	for t := range w.targets {
+		if t == "" || strings.HasPrefix(rel, t+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// child describes a subdirectory (ordinary or followed symlink) queued
+// for recursive visiting.
+type child struct {
+	name string
+	dir  string // absolute, already resolved if the entry was a symlink
+}
+
+// visit configures and visits the directory at dir (whose repository
+// relative path is rel), then recurses into its children as required by
+// the walker's mode.
+func (w *walker) visit(parent *config.Config, dir, rel string) {
+	if w.isStopped() {
+		return
+	}
+	prep, ok := w.prepareVisit(parent, dir, rel, w.markVisited)
+	if !ok {
+		return
+	}
+
+	if prep.recurse {
+		for _, ch := range prep.children {
+			if w.isStopped() {
+				break
+			}
+			childRel := prep.childRel(ch)
+			if w.shouldRecurse(rel, childRel) {
+				w.visit(prep.config, ch.dir, childRel)
+			}
+		}
+	}
+
+	if prep.shouldCallback() {
+		prep.deliver()
+	}
+}
+
+func (w *walker) markVisited(real string) bool {
+	if w.visitedReal[real] {
+		return false
+	}
+	w.visitedReal[real] = true
+	return true
+}
+
+// shouldRecurse reports whether Walk must descend into the child
+// directory childRel of rel, given the walker's mode: always for
+// "visit all" modes, when childRel lies on the path to one of the
+// requested directories, or when rel itself is already inside the
+// subtree of a requested directory and subdirectories are updated too.
+func (w *walker) shouldRecurse(rel, childRel string) bool {
+	return w.visitAll || w.isAncestorOfTarget(childRel) || (w.updateSubdirs && w.inTargetSubtree(rel))
+}
+
+// visitPrep holds the result of reading and configuring a single
+// directory: everything needed to recurse into its children and, once
+// they've been visited, deliver the post-order WalkFunc callback. It
+// lets the sequential and concurrent walkers share the exact same
+// pre-order logic (directory listing, Configure, exclude/symlink
+// resolution) while differing only in how children are traversed.
+type visitPrep struct {
+	w        *walker
+	dir, rel string
+	config   *config.Config
+	f        *rule.File
+	update   bool
+	subdirs  []string
+	regular  []string
+	genFiles []string
+	children []child
+	recurse  bool // false if a WalkFuncEx returned SkipDir or Stop for this directory
+}
+
+func (p *visitPrep) childRel(ch child) string {
+	return path.Join(p.rel, ch.name)
+}
+
+func (p *visitPrep) shouldCallback() bool {
+	return p.w.visitAll || p.w.targets[p.rel] || (p.w.updateSubdirs && p.w.inTargetSubtree(p.rel))
+}
+
+func (p *visitPrep) deliver() {
+	p.w.f(p.dir, p.rel, p.config, p.update, p.f, p.subdirs, p.regular, p.genFiles)
+}
+
+// prepFromCache rebuilds a visitPrep from a cache hit, replaying the
+// previously computed classification instead of re-matching exclude
+// patterns, re-collecting genFiles, or re-resolving symlinks. update is
+// recomputed live (not read from the cache entry) because it depends on
+// the set of directories passed to Walk, which isn't part of the
+// fingerprint and can differ between runs over an unchanged tree.
+func (w *walker) prepFromCache(dir, rel string, c *config.Config, f *rule.File, cached cacheEntry, recurse bool) *visitPrep {
+	children := make([]child, len(cached.Subdirs))
+	for i, name := range cached.Subdirs {
+		childDir := cached.SymlinkTargets[name]
+		if childDir == "" {
+			childDir = filepath.Join(dir, name)
+		}
+		children[i] = child{name, childDir}
+	}
+	wc := getWalkConfig(c)
+	updateEligible := w.targets[rel] || (w.updateSubdirs && w.inTargetSubtree(rel))
+	update := updateEligible && !wc.ignore && (cached.BuildFileName == "" || f != nil)
+	return &visitPrep{
+		w:        w,
+		dir:      dir,
+		rel:      rel,
+		config:   c,
+		f:        f,
+		update:   update,
+		subdirs:  cached.Subdirs,
+		regular:  cached.RegularFiles,
+		genFiles: cached.GenFiles,
+		children: children,
+		recurse:  recurse,
+	}
+}
+
+// prepareVisit reads dir, loads and configures its build file, and
+// classifies its entries into subdirectories (including followed
+// symlinks) and regular files, applying exclude patterns along the way.
+// markVisited is used to deduplicate directories reached through more
+// than one symlink; it returns false (and prepareVisit returns ok=false)
+// if dir was already visited. If w.preFunc is set, it's invoked here,
+// pre-order, and its WalkAction determines the returned visitPrep's
+// recurse field and, for Stop, halts the rest of the walk.
+func (w *walker) prepareVisit(parent *config.Config, dir, rel string, markVisited func(string) bool) (*visitPrep, bool) {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		if !markVisited(real) {
+			return nil, false
+		}
+	}
+
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		log.Print(err)
+		return nil, false
+	}
+
+	inheritedKey := strings.Join(walkConfigFingerprintKey(getWalkConfig(parent)), "\x00")
+	if prep, handled, ok := w.tryFastHit(parent, dir, rel, dirInfo, inheritedKey); handled {
+		return prep, ok
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Print(err)
+		return nil, false
+	}
+
+	c := parent.Clone()
+	buildFileName, buildPath := findBuildFile(c, dir, entries)
+	var f *rule.File
+	if buildPath != "" {
+		f, err = rule.LoadFile(buildPath, rel)
+		if err != nil {
+			log.Printf("%s: %v", buildPath, err)
+		}
+	}
+	for _, cext := range w.cexts {
+		cext.Configure(c, rel, f)
+	}
+	wc := getWalkConfig(c)
+	if err := w.checkDirectives(rel, f, wc.strictDirectives); err != nil {
+		w.setStrictErr(err)
+		return nil, false
+	}
+
+	var ownGitignorePatterns []gitignorePattern
+	if wc.respectGitignore {
+		if patterns, err := parseGitignore(dir, rel); err != nil {
+			log.Print(err)
+		} else if len(patterns) > 0 {
+			ownGitignorePatterns = patterns
+			wc.gitignorePatterns = append(wc.gitignorePatterns, patterns...)
+		}
+	}
+
+	if w.isExcluded(wc, rel, true) {
+		return nil, false
+	}
+
+	action := Continue
+	if w.preFunc != nil {
+		action = w.preFunc(dir, rel, c, f)
+		if action == Stop {
+			w.stop()
+		}
+	}
+	recurse := action == Continue
+
+	fp := w.fingerprintFor(parent, dir, dirInfo, entries, buildPath)
+	if cached, ok := w.cache.get(rel, fp); ok {
+		return w.prepFromCache(dir, rel, c, f, cached, recurse), true
+	}
+
+	var subdirNames, regularFiles []string
+	var children []child
+	symlinkTargets := make(map[string]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == buildFileName {
+			regularFiles = append(regularFiles, name)
+			continue
+		}
+		entryRel := path.Join(rel, name)
+		mode := entry.Type()
+		if w.isExcluded(wc, entryRel, mode.IsDir()) {
+			continue
+		}
+
+		if mode&os.ModeSymlink != 0 {
+			childDir, ok := w.resolveSymlink(wc, dir, name, entryRel)
+			if !ok {
+				continue
+			}
+			subdirNames = append(subdirNames, name)
+			symlinkTargets[name] = childDir
+			children = append(children, child{name, childDir})
+		} else if mode.IsDir() {
+			subdirNames = append(subdirNames, name)
+			children = append(children, child{name, filepath.Join(dir, name)})
+		} else {
+			regularFiles = append(regularFiles, name)
+		}
+	}
+	sort.Strings(subdirNames)
+	sort.Strings(regularFiles)
+
+	updateEligible := w.targets[rel] || (w.updateSubdirs && w.inTargetSubtree(rel))
+	update := updateEligible && !wc.ignore && (buildPath == "" || f != nil)
+	genFiles := w.genFilesFromRules(f, wc)
+
+	var buildFileSize, buildFileModTime int64
+	if buildPath != "" {
+		if info, err := os.Stat(buildPath); err == nil {
+			buildFileSize, buildFileModTime = info.Size(), info.ModTime().UnixNano()
+		}
+	}
+	hasGitignore, gitignoreSize, gitignoreModTime, _ := statOptional(filepath.Join(dir, ".gitignore"))
+
+	w.cache.put(rel, cacheEntry{
+		Fingerprint:         w.fingerprintFor(parent, dir, dirInfo, entries, buildPath),
+		Subdirs:             subdirNames,
+		RegularFiles:        regularFiles,
+		GenFiles:            genFiles,
+		SymlinkTargets:      symlinkTargets,
+		Mode:                w.mode,
+		DirModTime:          dirInfo.ModTime().UnixNano(),
+		InheritedKey:        inheritedKey,
+		ValidBuildFileNames: strings.Join(parent.ValidBuildFileNames, ","),
+		BuildFileName:       buildFileName,
+		BuildFileSize:       buildFileSize,
+		BuildFileModTime:    buildFileModTime,
+		HasGitignore:        hasGitignore,
+		GitignoreSize:       gitignoreSize,
+		GitignoreModTime:    gitignoreModTime,
+		GitignorePatterns:   ownGitignorePatterns,
+		Bazelignore:         strings.Join(w.bazelignore, ","),
+	})
+
+	return &visitPrep{
+		w:        w,
+		dir:      dir,
+		rel:      rel,
+		config:   c,
+		f:        f,
+		update:   update,
+		subdirs:  subdirNames,
+		regular:  regularFiles,
+		genFiles: genFiles,
+		children: children,
+		recurse:  recurse,
+	}, true
+}
+
+// statOptional stats path, distinguishing "doesn't exist" (a valid
+// state, reported as exists=false with ok=true) from a real stat
+// failure (ok=false), so a caller using it to validate a cache entry
+// can tell "confirmed absent" from "couldn't tell" and fall back
+// accordingly.
+func statOptional(path string) (exists bool, size, modTime int64, ok bool) {
+	info, err := os.Stat(path)
+	if err == nil {
+		return true, info.Size(), info.ModTime().UnixNano(), true
+	}
+	if os.IsNotExist(err) {
+		return false, 0, 0, true
+	}
+	return false, 0, 0, false
+}
+
+// tryFastHit checks whether dir can skip os.ReadDir and re-parsing its
+// .gitignore entirely, using only the cheap signals recorded on
+// cacheEntry: dir's own mtime, the inherited directive key, and the
+// (size, mtime) of its build file and .gitignore. rule.LoadFile and
+// Configure still run even on a fast hit, since every directory needs a
+// real *config.Config derived for its descendants and the post-order
+// callback needs the parsed build file; what this skips is the
+// os.ReadDir call and re-parsing the .gitignore that a plain cache hit
+// still pays for.
+//
+// It returns handled=false if no fast hit applies, telling the caller
+// to fall back to the full os.ReadDir path, which still consults the
+// cache with the complete fingerprint; a fast check that's wrong in the
+// conservative direction only costs a slower path, never an incorrect
+// one.
+func (w *walker) tryFastHit(parent *config.Config, dir, rel string, dirInfo os.FileInfo, inheritedKey string) (prep *visitPrep, handled, ok bool) {
+	candidate, found := w.cache.peek(rel)
+	if !found ||
+		candidate.Mode != w.mode ||
+		candidate.DirModTime != dirInfo.ModTime().UnixNano() ||
+		candidate.InheritedKey != inheritedKey ||
+		candidate.ValidBuildFileNames != strings.Join(parent.ValidBuildFileNames, ",") ||
+		candidate.Bazelignore != strings.Join(w.bazelignore, ",") {
+		return nil, false, false
+	}
+
+	buildPath := ""
+	if candidate.BuildFileName != "" {
+		buildPath = filepath.Join(dir, candidate.BuildFileName)
+	}
+	buildExists, buildSize, buildModTime, buildOK := statOptional(buildPath)
+	if buildPath == "" {
+		buildExists, buildOK = false, true
+	}
+	if !buildOK || buildExists != (candidate.BuildFileName != "") ||
+		buildSize != candidate.BuildFileSize || buildModTime != candidate.BuildFileModTime {
+		return nil, false, false
+	}
+
+	gitExists, gitSize, gitModTime, gitOK := statOptional(filepath.Join(dir, ".gitignore"))
+	if !gitOK || gitExists != candidate.HasGitignore ||
+		gitSize != candidate.GitignoreSize || gitModTime != candidate.GitignoreModTime {
+		return nil, false, false
+	}
+
+	c := parent.Clone()
+	var f *rule.File
+	var err error
+	if buildPath != "" {
+		f, err = rule.LoadFile(buildPath, rel)
+		if err != nil {
+			log.Printf("%s: %v", buildPath, err)
+		}
+	}
+	for _, cext := range w.cexts {
+		cext.Configure(c, rel, f)
+	}
+	wc := getWalkConfig(c)
+	if err := w.checkDirectives(rel, f, wc.strictDirectives); err != nil {
+		w.setStrictErr(err)
+		return nil, true, false
+	}
+
+	if wc.respectGitignore && len(candidate.GitignorePatterns) > 0 {
+		wc.gitignorePatterns = append(wc.gitignorePatterns, candidate.GitignorePatterns...)
+	}
+
+	if w.isExcluded(wc, rel, true) {
+		return nil, true, false
+	}
+
+	action := Continue
+	if w.preFunc != nil {
+		action = w.preFunc(dir, rel, c, f)
+		if action == Stop {
+			w.stop()
+		}
+	}
+
+	w.cache.confirmFastHit(rel, candidate)
+	return w.prepFromCache(dir, rel, c, f, candidate, action == Continue), true, true
+}
+
+// fileFingerprint returns the size and mtime of dir's build file and,
+// if present, its own .gitignore (rather than their full contents,
+// which are more expensive to hash), plus the loaded .bazelignore
+// patterns, since all three can affect classification but aren't
+// captured by the inherited directive keys in walkConfigFingerprintKey.
+func (w *walker) fileFingerprint(dir, buildPath string) string {
+	var parts []string
+	if buildPath != "" {
+		if info, err := os.Stat(buildPath); err == nil {
+			parts = append(parts, fmt.Sprintf("build:%d:%d", info.Size(), info.ModTime().UnixNano()))
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, ".gitignore")); err == nil {
+		parts = append(parts, fmt.Sprintf("gitignore:%d:%d", info.Size(), info.ModTime().UnixNano()))
+	}
+	parts = append(parts, "bazelignore:"+strings.Join(w.bazelignore, ","))
+	return strings.Join(parts, "|")
+}
+
+// fingerprintFor computes the cache fingerprint for the directory dir
+// given the state gathered while preparing to visit it.
+func (w *walker) fingerprintFor(parent *config.Config, dir string, dirInfo os.FileInfo, entries []os.DirEntry, buildPath string) string {
+	return fingerprint(dirInfo.ModTime().UnixNano(), entries, w.fileFingerprint(dir, buildPath), walkConfigFingerprintKey(getWalkConfig(parent)), w.mode)
+}
+
+// resolveSymlink decides whether the symlink dir/name should be treated
+// as a subdirectory. Symlinks that resolve outside the repository are
+// followed unless excluded. Symlinks that resolve inside the repository
+// are only followed if entryRel matches a "follow" directive.
+func (w *walker) resolveSymlink(wc *walkConfig, dir, name, entryRel string) (string, bool) {
+	real, err := filepath.EvalSymlinks(filepath.Join(dir, name))
+	if err != nil {
+		// Dangling symlink or a symlink loop.
+		return "", false
+	}
+	info, err := os.Stat(real)
+	if err != nil || !info.IsDir() {
+		return "", false
+	}
+	if isWithinRoot(real, w.c.RepoRoot) && !matchesAny(wc.follow, entryRel) {
+		return "", false
+	}
+	return real, true
+}
+
+// isWithinRoot reports whether path is root or a descendant of root.
+func isWithinRoot(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (!strings.HasPrefix(rel, "..") && !filepath.IsAbs(rel))
+}
+
+// findBuildFile returns the name and absolute path of the build file
+// that should be read in dir, using c.ValidBuildFileNames in priority
+// order. It returns "", "" if no build file is present.
+func findBuildFile(c *config.Config, dir string, entries []os.DirEntry) (string, string) {
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names[entry.Name()] = true
+		}
+	}
+	for _, name := range c.ValidBuildFileNames {
+		if names[name] {
+			return name, filepath.Join(dir, name)
+		}
+	}
+	return "", ""
+}
+
+// genFilesFromRules collects the names of generated files declared by
+// "out" and "outs" attributes of rules in f, in declaration order,
+// skipping any that are excluded.
+func (w *walker) genFilesFromRules(f *rule.File, wc *walkConfig) []string {
+	if f == nil {
+		return nil
+	}
+	var genFiles []string
+	for _, r := range f.Rules {
+		if out := r.AttrString("out"); out != "" && !w.isExcluded(wc, out, false) {
+			genFiles = append(genFiles, out)
+		}
+		for _, out := range r.AttrStrings("outs") {
+			if !w.isExcluded(wc, out, false) {
+				genFiles = append(genFiles, out)
+			}
+		}
+	}
+	return genFiles
+}
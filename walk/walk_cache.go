@@ -0,0 +1,268 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cacheEntry is the resolved, replayable outcome of visiting a single
+// directory, valid as long as its fingerprint is unchanged.
+type cacheEntry struct {
+	Fingerprint  string
+	Subdirs      []string
+	RegularFiles []string
+	GenFiles     []string
+
+	// SymlinkTargets maps the name of each entry in Subdirs that was a
+	// followed symlink to the absolute path it resolved to, so a cache
+	// hit doesn't need to call filepath.EvalSymlinks again.
+	SymlinkTargets map[string]string
+
+	// The fields below are the cheap, pre-readdir signals checked by
+	// (*walker).tryFastHit, which let a hit skip os.ReadDir and
+	// re-parsing dir's .gitignore, rather than just the classify/exclude
+	// loop a plain Fingerprint hit skips. DirModTime is dir's own mtime:
+	// on any POSIX filesystem, that changes whenever an entry is added,
+	// removed, or renamed, so an unchanged DirModTime means the entry
+	// list itself can't have changed. InheritedKey is the same inherited
+	// directive key used in Fingerprint. BuildFileName, BuildFileSize,
+	// and BuildFileModTime (and the .gitignore equivalents) catch edits
+	// to either file's content in place, which don't change dir's own
+	// mtime. GitignorePatterns is this directory's own newly parsed
+	// .gitignore (not the patterns it inherited), replayed on a fast hit
+	// instead of being parsed again. ValidBuildFileNames and Bazelignore
+	// are two more inputs that aren't otherwise captured above: the
+	// former decides which name BuildFileName is even allowed to be, and
+	// the latter (which fileFingerprint also folds into a plain hit's
+	// fingerprint) can exclude entries a directory listing would
+	// otherwise include.
+	Mode                Mode
+	DirModTime          int64
+	InheritedKey        string
+	ValidBuildFileNames string
+	BuildFileName       string
+	BuildFileSize       int64
+	BuildFileModTime    int64
+	HasGitignore        bool
+	GitignoreSize       int64
+	GitignoreModTime    int64
+	GitignorePatterns   []gitignorePattern
+	Bazelignore         string
+}
+
+// walkCache is a persistent, on-disk cache of cacheEntry values keyed by
+// a directory's repository-relative path. Configure must still run on
+// every directory on every run (descendants need its resulting
+// *config.Config, and the post-order callback needs the parsed build
+// file), so a cache hit can't skip re-parsing the build file with
+// rule.LoadFile. What it can skip, via (*walker).tryFastHit, is
+// os.ReadDir and re-parsing dir's .gitignore, using the cheap signals
+// recorded on cacheEntry; a plain get hit (the fallback when tryFastHit
+// doesn't apply) additionally skips re-matching exclude patterns,
+// re-collecting genFiles, and re-resolving symlinks.
+//
+// walkCache is safe for concurrent use by multiple goroutines, so that
+// it can back both Walk and WalkConcurrent.
+type walkCache struct {
+	path         string
+	disableFor   []string // rel-path prefixes that always bypass the cache
+	mu           sync.Mutex
+	loaded       map[string]cacheEntry // read from path at startup
+	recorded     map[string]cacheEntry // written by this run, saved at the end
+	hits, misses int
+}
+
+// loadWalkCache reads a cache file previously written by
+// (*walkCache).save. A missing file is not an error; it just yields an
+// empty cache.
+func loadWalkCache(path string, disableFor []string) (*walkCache, error) {
+	wc := &walkCache{
+		path:       path,
+		disableFor: disableFor,
+		loaded:     make(map[string]cacheEntry),
+		recorded:   make(map[string]cacheEntry),
+	}
+	if path == "" {
+		return wc, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return wc, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(&wc.loaded); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return wc, nil
+}
+
+// save writes the entries recorded during this run back to wc.path,
+// replacing its previous contents. It does nothing if no cache file was
+// configured.
+func (wc *walkCache) save() error {
+	if wc == nil || wc.path == "" {
+		return nil
+	}
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	f, err := os.Create(wc.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gob.NewEncoder(f).Encode(wc.recorded)
+}
+
+// disabled reports whether rel (or an ancestor of rel) was named in
+// -walk_cache_disable_for, forcing a fresh scan.
+func (wc *walkCache) disabled(rel string) bool {
+	for _, prefix := range wc.disableFor {
+		if rel == prefix || strings.HasPrefix(rel, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the cached entry for rel if its fingerprint still matches,
+// and records it as live so a subsequent save keeps it.
+func (wc *walkCache) get(rel, fingerprint string) (cacheEntry, bool) {
+	if wc == nil || wc.disabled(rel) {
+		return cacheEntry{}, false
+	}
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	entry, ok := wc.loaded[rel]
+	if !ok || entry.Fingerprint != fingerprint {
+		wc.misses++
+		return cacheEntry{}, false
+	}
+	wc.hits++
+	wc.recorded[rel] = entry
+	return entry, true
+}
+
+// peek returns the cached entry for rel, if any, without affecting
+// hit/miss bookkeeping, so (*walker).tryFastHit can check rel's cheap
+// pre-readdir signals before deciding whether to confirm a fast hit
+// with confirmFastHit. A failed check after peek isn't a real miss: the
+// caller falls back to the full os.ReadDir path, which still consults
+// get with the complete fingerprint.
+func (wc *walkCache) peek(rel string) (cacheEntry, bool) {
+	if wc == nil || wc.disabled(rel) {
+		return cacheEntry{}, false
+	}
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	entry, ok := wc.loaded[rel]
+	return entry, ok
+}
+
+// confirmFastHit records entry as used for rel, the same bookkeeping a
+// plain get hit performs, once tryFastHit has verified every signal it
+// needed still matches.
+func (wc *walkCache) confirmFastHit(rel string, entry cacheEntry) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.hits++
+	wc.recorded[rel] = entry
+}
+
+// put records the freshly computed entry for rel so it's persisted by
+// the next save, regardless of whether caching is disabled for rel (a
+// later run without -walk_cache_disable_for can then reuse it).
+func (wc *walkCache) put(rel string, entry cacheEntry) {
+	if wc == nil || wc.path == "" {
+		return
+	}
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.recorded[rel] = entry
+}
+
+// logStats prints a one-line cache hit/miss summary, if a cache was in use.
+func (wc *walkCache) logStats() {
+	if wc == nil || wc.path == "" {
+		return
+	}
+	log.Printf("walk cache %s: %d hits, %d misses", wc.path, wc.hits, wc.misses)
+}
+
+// fingerprint combines a directory's mtime, its sorted entry list, the
+// size and mtime of its build file (if any), and the hash of every
+// gazelle: directive inherited from its ancestors, so that it changes
+// whenever any input to classifying the directory changes. It
+// deliberately avoids hashing the build file's full content: the
+// (size, mtime) pair is cheap to obtain with a single os.Stat and is
+// sufficient to detect edits for this cache's purposes.
+func fingerprint(dirModTime int64, entries []os.DirEntry, buildInfo string, inherited []string, mode Mode) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "mtime:%d\n", dirModTime)
+	fmt.Fprintf(h, "mode:%d\n", mode)
+	fmt.Fprintf(h, "build:%s\n", buildInfo)
+
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name() + ":" + e.Type().String()
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		io.WriteString(h, n)
+		h.Write([]byte{'\n'})
+	}
+
+	directives := append([]string(nil), inherited...)
+	sort.Strings(directives)
+	for _, d := range directives {
+		io.WriteString(h, d)
+		h.Write([]byte{'\n'})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// walkConfigFingerprintKey returns a stable, order-independent
+// representation of wc's inherited directives, suitable for inclusion
+// in a fingerprint.
+func walkConfigFingerprintKey(wc *walkConfig) []string {
+	keys := make([]string, 0, len(wc.excludes)+len(wc.follow)+len(wc.gitignorePatterns)+3)
+	for _, e := range wc.excludes {
+		keys = append(keys, "exclude:"+e)
+	}
+	for _, f := range wc.follow {
+		keys = append(keys, "follow:"+f)
+	}
+	keys = append(keys, "ignore:"+strconv.FormatBool(wc.ignore))
+	keys = append(keys, "respect_gitignore:"+strconv.FormatBool(wc.respectGitignore))
+	keys = append(keys, "respect_bazelignore:"+strconv.FormatBool(wc.respectBazelignore))
+	for _, p := range wc.gitignorePatterns {
+		keys = append(keys, fmt.Sprintf("gitignore:%s:%v:%v:%v:%s", p.base, p.anchored, p.dirOnly, p.negated, strings.Join(p.segments, "/")))
+	}
+	return keys
+}
@@ -0,0 +1,176 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWalkCachePersistsAcrossRuns(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "a/b/"},
+		{Path: "a/c.go"},
+	})
+	defer cleanup()
+
+	cacheFile := filepath.Join(t.TempDir(), "walk-cache")
+
+	runOnce := func() []string {
+		args := []string{"-repo_root", dir, "-walk_cache", cacheFile}
+		cexts := []config.Configurer{&config.CommonConfigurer{}, &Configurer{}}
+		c := testtools.NewTestConfig(t, cexts, nil, args)
+		var rels []string
+		Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+			rels = append(rels, rel)
+		})
+		return rels
+	}
+
+	first := runOnce()
+	if _, err := os.Stat(cacheFile); err != nil {
+		t.Fatalf("-walk_cache did not create a cache file: %v", err)
+	}
+	second := runOnce()
+
+	if diff := cmp.Diff(first, second); diff != "" {
+		t.Errorf("second run with a warm cache visited different directories (-first +second):\n%s", diff)
+	}
+}
+
+func TestWalkCacheInvalidatesMutatedDirectoryAndDescendants(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "a/BUILD.bazel", Content: "# gazelle:exclude ignored.txt"},
+		{Path: "a/keep.txt"},
+		{Path: "a/b/c.go"},
+		{Path: "sibling/d.go"},
+	})
+	defer cleanup()
+
+	cacheFile := filepath.Join(t.TempDir(), "walk-cache")
+	args := []string{"-repo_root", dir, "-walk_cache", cacheFile}
+
+	run := func() *walkCache {
+		cexts := []config.Configurer{&config.CommonConfigurer{}, &Configurer{}}
+		c := testtools.NewTestConfig(t, cexts, nil, args)
+		Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, _ string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {})
+		return getWalkCache(c)
+	}
+
+	run() // warm the cache
+
+	// Add a second exclude directive to a/BUILD.bazel. This changes a's
+	// own fingerprint (its build file's size/mtime) and, because a/b
+	// inherits a's directives, a/b's fingerprint too.
+	content := "# gazelle:exclude ignored.txt\n# gazelle:exclude other.txt\n"
+	if err := os.WriteFile(filepath.Join(dir, "a", "BUILD.bazel"), []byte(content), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	wc := run()
+	missed := func(rel string) bool {
+		loaded, ok := wc.loaded[rel]
+		return !ok || loaded.Fingerprint != wc.recorded[rel].Fingerprint
+	}
+	if !missed("a") {
+		t.Errorf("expected a's cache entry to miss after editing its build file")
+	}
+	if !missed("a/b") {
+		t.Errorf("expected a/b to miss the cache: it inherits a's changed exclude directive")
+	}
+	if missed("sibling") {
+		t.Errorf("expected the unrelated sibling directory to still hit the cache")
+	}
+}
+
+func TestWalkCacheFastPathSkipsReadDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("running as root: directory read permission is not enforced, so this test can't observe anything")
+	}
+
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "a/BUILD.bazel", Content: "# gazelle:exclude ignored.txt"},
+		{Path: "a/keep.txt"},
+		{Path: "a/ignored.txt"},
+	})
+	defer cleanup()
+
+	cacheFile := filepath.Join(t.TempDir(), "walk-cache")
+	args := []string{"-repo_root", dir, "-walk_cache", cacheFile}
+
+	run := func() []string {
+		cexts := []config.Configurer{&config.CommonConfigurer{}, &Configurer{}}
+		c := testtools.NewTestConfig(t, cexts, nil, args)
+		var rels []string
+		Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+			rels = append(rels, rel)
+		})
+		return rels
+	}
+
+	first := run()
+
+	// Remove read (but not execute/search) permission on "a". A fast
+	// hit only needs to stat known paths by name, which execute
+	// permission on the directory allows; os.ReadDir additionally needs
+	// read permission and would fail here, so this proves the fast path
+	// genuinely avoids it rather than just being allowed to.
+	aDir := filepath.Join(dir, "a")
+	info, err := os.Stat(aDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(aDir, 0o111); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(aDir, info.Mode())
+
+	second := run()
+	if diff := cmp.Diff(first, second); diff != "" {
+		t.Errorf("second run with read permission removed from %q visited a different set of directories (-first +second); want the fast hit to avoid os.ReadDir there entirely:\n%s", aDir, diff)
+	}
+}
+
+func TestFingerprintChangesWithInputs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := fingerprint(1000, entries, "", nil, VisitAllUpdateSubdirsMode)
+	if got := fingerprint(1000, entries, "", nil, VisitAllUpdateSubdirsMode); got != base {
+		t.Errorf("fingerprint is not deterministic for identical inputs")
+	}
+	if got := fingerprint(1001, entries, "", nil, VisitAllUpdateSubdirsMode); got == base {
+		t.Errorf("fingerprint did not change when dir mtime changed")
+	}
+	if got := fingerprint(1000, entries, "", nil, UpdateDirsMode); got == base {
+		t.Errorf("fingerprint did not change when Mode changed")
+	}
+	if got := fingerprint(1000, entries, "", []string{"exclude:x"}, VisitAllUpdateSubdirsMode); got == base {
+		t.Errorf("fingerprint did not change when inherited directives changed")
+	}
+}
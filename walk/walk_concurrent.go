@@ -0,0 +1,173 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+)
+
+// WalkConcurrent is like Walk, but parallelizes the pre-order phase of
+// the traversal (readdir, symlink resolution, and exclude matching)
+// across up to workers goroutines. Each directory's goroutine holds a
+// worker slot only while running its own pre-order phase, not while
+// recursing into or waiting on its children, so the bound applies to
+// I/O concurrency, not to the number of directories in flight.
+//
+// The invariants of Walk are preserved: Configure is still invoked on
+// every Configurer in cexts top-down, in a deterministic
+// parent-before-child order within each subtree (a child only starts
+// once its parent's clone of *config.Config has been produced), and cb
+// is still delivered post-order per subtree, after all of that
+// subtree's descendants have been visited.
+//
+// workers <= 1 behaves exactly like Walk.
+func WalkConcurrent(c *config.Config, cexts []config.Configurer, dirs []string, mode Mode, workers int, cb WalkFunc) error {
+	if workers <= 1 {
+		return Walk(c, cexts, dirs, mode, cb)
+	}
+
+	targets := targetRelSet(c.RepoRoot, dirs)
+	bazelignore, err := loadBazelignore(c.RepoRoot)
+	if err != nil {
+		log.Print(err)
+	}
+	cw := &concurrentWalker{
+		walker: walker{
+			c:               c,
+			cexts:           cexts,
+			targets:         targets,
+			visitAll:        mode == VisitAllUpdateSubdirsMode || mode == VisitAllUpdateDirsMode,
+			updateSubdirs:   mode == VisitAllUpdateSubdirsMode || mode == UpdateSubdirsMode,
+			visitedReal:     make(map[string]bool),
+			cache:           getWalkCache(c),
+			mode:            mode,
+			f:               cb,
+			bazelignore:     bazelignore,
+			knownDirectives: unionKnownDirectives(cexts),
+			warned:          make(map[string]bool),
+		},
+		sem: make(chan struct{}, workers),
+	}
+	cw.visit(c, c.RepoRoot, "", false)
+	if err := cw.cache.save(); err != nil {
+		log.Print(err)
+	}
+	cw.cache.logStats()
+	return cw.strictErr()
+}
+
+// concurrentWalker runs the same pre-order/post-order algorithm as
+// walker, but forks one goroutine per child directory, bounded by a
+// worker-pool semaphore, and joins on a barrier (sync.WaitGroup) before
+// delivering the post-order callback for a directory.
+type concurrentWalker struct {
+	walker
+	sem  chan struct{}
+	mu   sync.Mutex // guards walker.visitedReal
+	cbMu sync.Mutex // serializes delivery of the user's WalkFunc
+}
+
+func (cw *concurrentWalker) markVisited(real string) bool {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.visitedReal[real] {
+		return false
+	}
+	cw.visitedReal[real] = true
+	return true
+}
+
+// alreadyClaimed is passed to prepareVisit in place of markVisited when
+// visit's caller has already claimed dir's symlink dedup entry on its
+// behalf, so prepareVisit doesn't redundantly (and incorrectly) treat
+// the rightful visitor as a second, losing claimant.
+func alreadyClaimed(string) bool { return true }
+
+// visit mirrors walker.visit, but recurses into children concurrently
+// and waits for all of them (the barrier) before calling cw.f. cw.sem is
+// held only around cw.prepareVisit, the pre-order I/O this type exists
+// to parallelize, never across recursion into children or the
+// wg.Wait() barrier below: a goroutine blocked in wg.Wait() while still
+// holding a slot would make that slot unavailable to its own children,
+// and on an unbranched chain deeper than len(cw.sem) every goroutine
+// would end up doing exactly that, deadlocking the whole walk.
+//
+// claimed reports whether the caller already resolved dir's symlink
+// dedup entry on its behalf (see below); it is false for the initial
+// call on the repository root and for a child whose path
+// filepath.EvalSymlinks could not resolve.
+func (cw *concurrentWalker) visit(parent *config.Config, dir, rel string, claimed bool) {
+	if cw.isStopped() {
+		return
+	}
+
+	markVisited := cw.markVisited
+	if claimed {
+		markVisited = alreadyClaimed
+	}
+
+	cw.sem <- struct{}{}
+	prep, ok := cw.prepareVisit(parent, dir, rel, markVisited)
+	<-cw.sem
+	if !ok {
+		return
+	}
+
+	var wg sync.WaitGroup
+	if prep.recurse {
+		for _, ch := range prep.children {
+			if cw.isStopped() {
+				break
+			}
+			childRel := prep.childRel(ch)
+			if !cw.shouldRecurse(rel, childRel) {
+				continue
+			}
+			// Resolve and claim ch's symlink dedup entry here, synchronously
+			// and in prep.children's (name-sorted) order, rather than racing
+			// inside the spawned goroutine: two children of dir that both
+			// resolve to the same real directory (e.g. two symlinks to the
+			// same target) must have a deterministic winner, matching the
+			// order sequential Walk would visit them in, not whichever
+			// goroutine happens to reach markVisited first.
+			claimedChild := false
+			if real, err := filepath.EvalSymlinks(ch.dir); err == nil {
+				if !cw.markVisited(real) {
+					continue
+				}
+				claimedChild = true
+			}
+			ch := ch
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cw.visit(prep.config, ch.dir, childRel, claimedChild)
+			}()
+		}
+	}
+	wg.Wait() // barrier: the post-order callback below only fires after every descendant has returned.
+
+	if !prep.shouldCallback() {
+		return
+	}
+	cw.cbMu.Lock()
+	defer cw.cbMu.Unlock()
+	prep.deliver()
+}
@@ -0,0 +1,159 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWalkConcurrentMatchesWalk(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "update/sub/"},
+		{Path: "update/sub/sub/"},
+		{
+			Path:    "update/ignore/BUILD.bazel",
+			Content: "# gazelle:ignore",
+		},
+		{Path: "update/ignore/sub/"},
+		{Path: "update/other/a/b/c/"},
+	})
+	defer cleanup()
+
+	for _, workers := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			c, cexts := testConfig(t, dir)
+			var mu sync.Mutex
+			var rels []string
+			WalkConcurrent(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, workers, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+				mu.Lock()
+				rels = append(rels, rel)
+				mu.Unlock()
+			})
+
+			c2, cexts2 := testConfig(t, dir)
+			var want []string
+			Walk(c2, cexts2, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+				want = append(want, rel)
+			})
+
+			sort.Strings(rels)
+			sort.Strings(want)
+			if diff := cmp.Diff(want, rels); diff != "" {
+				t.Errorf("WalkConcurrent visited a different set of directories than Walk (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestWalkConcurrentUnbranchedChainDeeperThanWorkers guards against a
+// deadlock where a goroutine holds its worker slot for its entire
+// subtree recursion instead of just its own pre-order phase: on a chain
+// with no branching, every ancestor goroutine would then be blocked in
+// wg.Wait() while still occupying a slot, leaving none free for the
+// next level to acquire once the chain is deeper than workers.
+func TestWalkConcurrentUnbranchedChainDeeperThanWorkers(t *testing.T) {
+	const workers = 2
+	const depth = 5 // deeper than workers, with no siblings to free up slots
+
+	segs := make([]string, depth)
+	for i := range segs {
+		segs[i] = fmt.Sprintf("d%d", i)
+	}
+	leaf := strings.Join(segs, "/")
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: leaf + "/"}})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	done := make(chan []string, 1)
+	go func() {
+		var rels []string
+		WalkConcurrent(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, workers, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+			rels = append(rels, rel)
+		})
+		done <- rels
+	}()
+
+	select {
+	case rels := <-done:
+		if want := depth + 1; len(rels) != want {
+			t.Errorf("visited %d directories; want %d", len(rels), want)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("WalkConcurrent with workers=%d deadlocked on an unbranched chain of depth %d", workers, depth)
+	}
+}
+
+func TestWalkConcurrentOnePreservesCallbackOrder(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{{Path: "a/b/"}})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	var rels []string
+	WalkConcurrent(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, 1, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+		rels = append(rels, rel)
+	})
+	want := []string{"a/b", "a", ""}
+	if diff := cmp.Diff(want, rels); diff != "" {
+		t.Errorf("callback order (-want +got):\n%s", diff)
+	}
+}
+
+// TestWalkConcurrentSymlinksDeterministic guards against the symlink
+// dedup winner (walker.visitedReal) being decided by goroutine
+// scheduling: "b" and "b2" both resolve to the same real directory, so
+// exactly one must be visited, and it must be "b" on every run, matching
+// what sequential Walk does (see TestSymlinksChained).
+func TestWalkConcurrentSymlinksDeterministic(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks not supported on windows")
+	}
+	files := []testtools.FileSpec{
+		{Path: "root/b", Symlink: "../b"},
+		{Path: "root/b2", Symlink: "../b"},
+		{Path: "b/b.go", Content: "package b"},
+	}
+	dir, cleanup := testtools.CreateFiles(t, files)
+	defer cleanup()
+
+	root := filepath.Join(dir, "root")
+	for run := 0; run < 10; run++ {
+		c, cexts := testConfig(t, root)
+		var mu sync.Mutex
+		var rels []string
+		WalkConcurrent(c, cexts, []string{root}, VisitAllUpdateSubdirsMode, 8, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+			mu.Lock()
+			rels = append(rels, rel)
+			mu.Unlock()
+		})
+		sort.Strings(rels)
+		want := []string{"", "b"}
+		if diff := cmp.Diff(want, rels); diff != "" {
+			t.Errorf("run %d: WalkConcurrent relative paths (-want +got):\n%s", run, diff)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// maxDirectiveSuggestionLen bounds the rune length of the directive names
+// compared by damerauLevenshtein. Directive names are short identifiers;
+// anything longer is almost certainly not a typo of a known directive, so
+// it's cheaper to skip the comparison than to build its DP table.
+const maxDirectiveSuggestionLen = 64
+
+// unionKnownDirectives returns the set of directive names recognized by
+// any Configurer in cexts, so Walk can warn about "# gazelle:" directives
+// that no registered extension understands.
+func unionKnownDirectives(cexts []config.Configurer) map[string]bool {
+	known := make(map[string]bool)
+	for _, cext := range cexts {
+		for _, name := range cext.KnownDirectives() {
+			known[name] = true
+		}
+	}
+	return known
+}
+
+// checkDirectives warns about any directive in f that isn't in
+// w.knownDirectives, including a "did you mean" suggestion when one of
+// the known names is a plausible typo. Each (rel, directive) pair is
+// only reported once per run. If strict is true, checkDirectives instead
+// returns an error for the first unrecognized directive, letting the
+// caller halt the walk while still running its normal cleanup (saving
+// the walk cache, delivering post-order callbacks for ancestors already
+// on the call stack) instead of exiting the process outright.
+func (w *walker) checkDirectives(rel string, f *rule.File, strict bool) error {
+	if f == nil {
+		return nil
+	}
+	for _, d := range f.Directives {
+		if w.knownDirectives[d.Key] {
+			continue
+		}
+		key := rel + "\x00" + d.Key
+		w.warnedMu.Lock()
+		if w.warned[key] {
+			w.warnedMu.Unlock()
+			continue
+		}
+		w.warned[key] = true
+		w.warnedMu.Unlock()
+
+		msg := fmt.Sprintf("%s: unrecognized directive \"gazelle:%s\"", rel, d.Key)
+		if suggestions := suggestDirectives(d.Key, w.knownDirectives); len(suggestions) > 0 {
+			msg += fmt.Sprintf("; did you mean %s?", strings.Join(suggestions, " or "))
+		}
+		if strict {
+			return errors.New(msg)
+		}
+		log.Print(msg)
+	}
+	return nil
+}
+
+// suggestDirectives returns directive names from known that are likely
+// typos of name, ranked by Damerau-Levenshtein distance then
+// lexicographically. If any known name is within distance 2, only the
+// single closest is returned; otherwise, up to the three closest within
+// distance 3 are returned.
+func suggestDirectives(name string, known map[string]bool) []string {
+	type candidate struct {
+		name string
+		dist int
+	}
+	var within2, within3 []candidate
+	for k := range known {
+		d := damerauLevenshtein(name, k)
+		if d < 0 {
+			continue
+		}
+		if d <= 2 {
+			within2 = append(within2, candidate{k, d})
+		} else if d <= 3 {
+			within3 = append(within3, candidate{k, d})
+		}
+	}
+	rank := func(cs []candidate) {
+		sort.Slice(cs, func(i, j int) bool {
+			if cs[i].dist != cs[j].dist {
+				return cs[i].dist < cs[j].dist
+			}
+			return cs[i].name < cs[j].name
+		})
+	}
+	if len(within2) > 0 {
+		rank(within2)
+		return []string{within2[0].name}
+	}
+	rank(within3)
+	if len(within3) > 3 {
+		within3 = within3[:3]
+	}
+	names := make([]string, len(within3))
+	for i, c := range within3 {
+		names[i] = c.name
+	}
+	return names
+}
+
+// damerauLevenshtein returns the optimal-string-alignment distance
+// between a and b: the minimum number of single-character insertions,
+// deletions, substitutions or adjacent transpositions needed to turn a
+// into b. It returns -1 without comparing if either string is longer
+// than maxDirectiveSuggestionLen runes.
+func damerauLevenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) > maxDirectiveSuggestionLen || len(rb) > maxDirectiveSuggestionLen {
+		return -1
+	}
+	n, m := len(ra), len(rb)
+	d := make([][]int, n+1)
+	for i := range d {
+		d[i] = make([]int, m+1)
+		d[i][0] = i
+	}
+	for j := 0; j <= m; j++ {
+		d[0][j] = j
+	}
+	for i := 1; i <= n; i++ {
+		for j := 1; j <= m; j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			del := d[i-1][j] + 1
+			ins := d[i][j-1] + 1
+			sub := d[i-1][j-1] + cost
+			best := del
+			if ins < best {
+				best = ins
+			}
+			if sub < best {
+				best = sub
+			}
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				if t := d[i-2][j-2] + cost; t < best {
+					best = t
+				}
+			}
+			d[i][j] = best
+		}
+	}
+	return d[n][m]
+}
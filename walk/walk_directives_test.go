@@ -0,0 +1,141 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDamerauLevenshtein(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"exclude", "exclude", 0},
+		{"excludes", "exclude", 1}, // one deletion
+		{"excude", "exclude", 1},   // one insertion
+		{"exclude", "exclued", 1},  // one transposition
+		{"build_filename", "build_file_name", 1},
+		{"ignore", "follow", 6},
+	} {
+		if got := damerauLevenshtein(tc.a, tc.b); got != tc.want {
+			t.Errorf("damerauLevenshtein(%q, %q) = %d; want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestDamerauLevenshteinCapsLongStrings(t *testing.T) {
+	long := strings.Repeat("x", maxDirectiveSuggestionLen+1)
+	if got := damerauLevenshtein(long, "exclude"); got != -1 {
+		t.Errorf("damerauLevenshtein with an over-long argument = %d; want -1", got)
+	}
+}
+
+func TestSuggestDirectives(t *testing.T) {
+	known := map[string]bool{"exclude": true, "follow": true, "ignore": true, "respect_gitignore": true}
+
+	if got, want := suggestDirectives("excludes", known), []string{"exclude"}; cmp.Diff(got, want) != "" {
+		t.Errorf("suggestDirectives(%q) = %v; want %v", "excludes", got, want)
+	}
+	if got := suggestDirectives("xyzxyzxyz", known); len(got) != 0 {
+		t.Errorf("suggestDirectives(%q) = %v; want no suggestions", "xyzxyzxyz", got)
+	}
+}
+
+func TestUnknownDirectiveWarning(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:excludes foo",
+		},
+		{Path: "foo"},
+	})
+	defer cleanup()
+
+	var buf bytes.Buffer
+	old := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(old)
+
+	c, cexts := testConfig(t, dir)
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, _ string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {})
+
+	got := buf.String()
+	if !strings.Contains(got, `unrecognized directive "gazelle:excludes"`) {
+		t.Errorf("expected a warning about the unrecognized directive, got log output:\n%s", got)
+	}
+	if !strings.Contains(got, "did you mean exclude?") {
+		t.Errorf("expected the warning to suggest \"exclude\", got log output:\n%s", got)
+	}
+}
+
+func TestStrictDirectivesReturnsError(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:strict_directives true\n# gazelle:excludes foo",
+		},
+		{Path: "foo"},
+		{Path: "ok/BUILD.bazel"},
+	})
+	defer cleanup()
+
+	var visited []string
+	c, cexts := testConfig(t, dir)
+	err := Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+		visited = append(visited, rel)
+	})
+
+	if err == nil {
+		t.Fatal("expected an error for the unrecognized directive under strict_directives")
+	}
+	if !strings.Contains(err.Error(), `unrecognized directive "gazelle:excludes"`) {
+		t.Errorf("err = %q; want it to mention the unrecognized directive", err)
+	}
+	if len(visited) != 0 {
+		t.Errorf("expected the walk to halt before delivering any callback, got %v", visited)
+	}
+}
+
+func TestUnknownDirectiveWarnedOncePerDir(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:excludes foo\n# gazelle:excludes bar",
+		},
+	})
+	defer cleanup()
+
+	var buf bytes.Buffer
+	old := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(old)
+
+	c, cexts := testConfig(t, dir)
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, _ string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {})
+
+	if n := strings.Count(buf.String(), `unrecognized directive "gazelle:excludes"`); n != 1 {
+		t.Errorf("got %d warnings for repeated directive %q in the same directory; want 1\nlog output:\n%s", n, "excludes", buf.String())
+	}
+}
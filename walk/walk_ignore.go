@@ -0,0 +1,187 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path"
+	"strings"
+)
+
+// loadBazelignore reads .bazelignore from the repository root, if
+// present. Each non-blank, non-comment line names a directory relative
+// to the root (a trailing slash is accepted but not required); that
+// directory and everything under it is excluded.
+func loadBazelignore(repoRoot string) ([]string, error) {
+	f, err := os.Open(path.Join(repoRoot, ".bazelignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, strings.TrimSuffix(line, "/"))
+	}
+	return patterns, scanner.Err()
+}
+
+// matchesBazelignore reports whether candidate is exactly one of the
+// ignored directories, or lies beneath one of them.
+func matchesBazelignore(patterns []string, candidate string) bool {
+	for _, p := range patterns {
+		if candidate == p || strings.HasPrefix(candidate, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// gitignorePattern is a single line from a .gitignore file, resolved
+// against the directory it was declared in.
+type gitignorePattern struct {
+	base     string // slash-separated rel path of the directory containing the .gitignore
+	segments []string
+	anchored bool // pattern contains a "/" before its end, so it's relative to base only
+	dirOnly  bool // pattern ended in "/", so it only matches directories
+	negated  bool // pattern started with "!"
+}
+
+// gobGitignorePattern is the exported mirror of gitignorePattern's
+// fields, used only by GobEncode/GobDecode: gob silently drops
+// unexported fields, but the walk cache persists a directory's parsed
+// .gitignore patterns across runs, so gitignorePattern needs a custom
+// encoding to round-trip through it.
+type gobGitignorePattern struct {
+	Base     string
+	Segments []string
+	Anchored bool
+	DirOnly  bool
+	Negated  bool
+}
+
+func (p gitignorePattern) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(gobGitignorePattern{
+		Base:     p.base,
+		Segments: p.segments,
+		Anchored: p.anchored,
+		DirOnly:  p.dirOnly,
+		Negated:  p.negated,
+	})
+	return buf.Bytes(), err
+}
+
+func (p *gitignorePattern) GobDecode(data []byte) error {
+	var g gobGitignorePattern
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&g); err != nil {
+		return err
+	}
+	*p = gitignorePattern{
+		base:     g.Base,
+		segments: g.Segments,
+		anchored: g.Anchored,
+		dirOnly:  g.DirOnly,
+		negated:  g.Negated,
+	}
+	return nil
+}
+
+// parseGitignore reads the .gitignore at dir (whose repository-relative
+// path is rel), returning one gitignorePattern per non-blank,
+// non-comment line.
+func parseGitignore(dir, rel string) ([]gitignorePattern, error) {
+	f, err := os.Open(path.Join(dir, ".gitignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []gitignorePattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := gitignorePattern{base: rel}
+		if strings.HasPrefix(line, "!") {
+			p.negated = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// A "/" anywhere but the end anchors the pattern to base; a
+		// leading "/" anchors it even with no other separator (e.g.
+		// "/foo" only matches "foo" in base, not at every depth), so
+		// this must be computed before the leading "/" is trimmed.
+		p.anchored = strings.Contains(line, "/") || strings.HasPrefix(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		p.segments = splitPath(line)
+		patterns = append(patterns, p)
+	}
+	return patterns, scanner.Err()
+}
+
+// matchesGitignore reports whether candidate is ignored by patterns,
+// applying git's "last matching pattern wins" rule, including
+// negation with "!".
+func matchesGitignore(patterns []gitignorePattern, candidate string, isDir bool) bool {
+	ignored := false
+	for _, p := range patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		rel := candidate
+		if p.base != "" {
+			if candidate == p.base {
+				rel = ""
+			} else if strings.HasPrefix(candidate, p.base+"/") {
+				rel = candidate[len(p.base)+1:]
+			} else {
+				continue // candidate is not under the directory that declared this pattern
+			}
+		}
+		if rel == "" {
+			continue
+		}
+		if p.anchored {
+			if !matchSegments(p.segments, splitPath(rel)) {
+				continue
+			}
+		} else {
+			if !matchSegments(append([]string{"**"}, p.segments...), splitPath(rel)) {
+				continue
+			}
+		}
+		ignored = !p.negated
+	}
+	return ignored
+}
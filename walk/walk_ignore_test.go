@@ -0,0 +1,187 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"path"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGitignoreBasic(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path: ".gitignore",
+			Content: `
+*.log
+build/
+!important.log
+`,
+		},
+		{Path: "a.log"},         // ignored by '*.log'
+		{Path: "important.log"}, // un-ignored by '!important.log'
+		{Path: "build/x"},       // the directory "build" is ignored by 'build/', so never descended
+		{Path: "keep.txt"},      // not ignored
+	})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	var files []string
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, regularFiles, _ []string) {
+		for _, f := range regularFiles {
+			files = append(files, path.Join(rel, f))
+		}
+	})
+	want := []string{".gitignore", "important.log", "keep.txt"}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("Walk files (-want +got):\n%s", diff)
+	}
+}
+
+func TestGitignoreCascade(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: ".gitignore", Content: "*.log"},
+		{Path: "b.log"}, // ignored by the root pattern
+		{Path: "a/BUILD.bazel"},
+		{Path: "a/.gitignore", Content: "!a.log"},
+		{Path: "a/a.log"}, // un-ignored by a/.gitignore, overriding the inherited pattern
+		{Path: "a/b.log"}, // still ignored; a/.gitignore doesn't mention it
+	})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	var files []string
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, regularFiles, _ []string) {
+		for _, f := range regularFiles {
+			files = append(files, path.Join(rel, f))
+		}
+	})
+	want := []string{"a/.gitignore", "a/BUILD.bazel", "a/a.log", ".gitignore"}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("Walk files (-want +got):\n%s", diff)
+	}
+}
+
+func TestGitignoreDisabledByDirective(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{
+			Path:    "BUILD.bazel",
+			Content: "# gazelle:respect_gitignore false",
+		},
+		{Path: ".gitignore", Content: "a.log"},
+		{Path: "a.log"}, // would be ignored, but respect_gitignore is off
+	})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	var files []string
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, regularFiles, _ []string) {
+		for _, f := range regularFiles {
+			files = append(files, path.Join(rel, f))
+		}
+	})
+	want := []string{".gitignore", "BUILD.bazel", "a.log"}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("Walk files (-want +got):\n%s", diff)
+	}
+}
+
+func TestBazelignore(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: ".bazelignore", Content: "ignored\nalso/ignored\n"},
+		{Path: "ignored/x"},
+		{Path: "also/ignored/y"},
+		{Path: "also/kept"},
+	})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	var rels []string
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+		rels = append(rels, rel)
+	})
+	want := []string{"also", ""}
+	if diff := cmp.Diff(want, rels); diff != "" {
+		t.Errorf("Walk dirs (-want +got):\n%s", diff)
+	}
+}
+
+func TestGitignoreLeadingSlashAnchored(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: ".gitignore", Content: "/foo"},
+		{Path: "foo"},   // ignored: anchored to the root by the leading "/"
+		{Path: "a/foo"}, // not ignored: the anchor is root-only, not every depth
+		{Path: "a/BUILD.bazel"},
+	})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	var files []string
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_ string, rel string, _ *config.Config, _ bool, _ *rule.File, _, regularFiles, _ []string) {
+		for _, f := range regularFiles {
+			files = append(files, path.Join(rel, f))
+		}
+	})
+	want := []string{"a/BUILD.bazel", "a/foo", ".gitignore"}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("Walk files (-want +got):\n%s", diff)
+	}
+}
+
+func TestMatchesGitignoreNegationAndDirOnly(t *testing.T) {
+	patterns := []gitignorePattern{
+		{segments: []string{"*.log"}},
+		{segments: []string{"build"}, dirOnly: true},
+		{segments: []string{"build", "keep.txt"}, anchored: true, negated: true},
+	}
+	for _, tc := range []struct {
+		candidate string
+		isDir     bool
+		want      bool
+	}{
+		{"a.log", false, true},
+		{"build", true, true},
+		{"build", false, false}, // the dirOnly pattern doesn't match a regular file named "build"
+		{"build/keep.txt", false, false},
+		{"build/other.txt", false, false},
+	} {
+		if got := matchesGitignore(patterns, tc.candidate, tc.isDir); got != tc.want {
+			t.Errorf("matchesGitignore(%q, isDir=%v) = %v; want %v", tc.candidate, tc.isDir, got, tc.want)
+		}
+	}
+}
+
+func TestMatchesGitignoreCascade(t *testing.T) {
+	parent := []gitignorePattern{
+		{segments: []string{"a.log"}},
+	}
+	// a/.gitignore un-ignores a.log within "a", overriding the parent pattern.
+	child := append(append([]gitignorePattern{}, parent...), gitignorePattern{base: "a", segments: []string{"a.log"}, negated: true})
+
+	if !matchesGitignore(parent, "a.log", false) {
+		t.Errorf("expected a.log to be ignored by the root pattern")
+	}
+	if matchesGitignore(child, "a/a.log", false) {
+		t.Errorf("expected a/.gitignore to override the inherited pattern for a/a.log")
+	}
+	if !matchesGitignore(child, "b/a.log", false) {
+		t.Errorf("expected the inherited pattern to still apply outside of a/")
+	}
+}
@@ -0,0 +1,107 @@
+/* Copyright 2018 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package walk
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/testtools"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWalkExSkipDir(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "a/x"},
+		{Path: "b/sub/"},
+		{Path: "c/y"},
+	})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	pre := func(_, rel string, _ *config.Config, _ *rule.File) WalkAction {
+		if rel == "b" {
+			return SkipDir
+		}
+		return Continue
+	}
+	var visited []string
+	post := func(_, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+		visited = append(visited, rel)
+	}
+	WalkEx(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, pre, post)
+
+	// "b/sub" is never visited: SkipDir prunes b's children. Its
+	// sibling "c" is unaffected, since SkipDir only prunes the
+	// directory it was returned for.
+	want := []string{"a", "b", "c", ""}
+	if diff := cmp.Diff(want, visited); diff != "" {
+		t.Errorf("visited dirs (-want +got):\n%s", diff)
+	}
+}
+
+func TestWalkExStop(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "a/sub/"},
+		{Path: "b/sub/"},
+		{Path: "c/sub/"},
+	})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	pre := func(_, rel string, _ *config.Config, _ *rule.File) WalkAction {
+		if rel == "b" {
+			return Stop
+		}
+		return Continue
+	}
+	var visited []string
+	post := func(_, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+		visited = append(visited, rel)
+	}
+	WalkEx(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, pre, post)
+
+	// "a" and "a/sub" were already descended into before Stop was
+	// returned for "b", so they still get their post-order callback.
+	// "b/sub" (b's own children) and "c" (a sibling reached only after
+	// the Stop) are never visited at all.
+	want := []string{"a/sub", "a", "b", ""}
+	if diff := cmp.Diff(want, visited); diff != "" {
+		t.Errorf("visited dirs (-want +got):\n%s", diff)
+	}
+}
+
+func TestWalkExNilPreBehavesLikeWalk(t *testing.T) {
+	dir, cleanup := testtools.CreateFiles(t, []testtools.FileSpec{
+		{Path: "a/sub/"},
+		{Path: "b/"},
+	})
+	defer cleanup()
+
+	c, cexts := testConfig(t, dir)
+	var viaWalk, viaWalkEx []string
+	Walk(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, func(_, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+		viaWalk = append(viaWalk, rel)
+	})
+	WalkEx(c, cexts, []string{dir}, VisitAllUpdateSubdirsMode, nil, func(_, rel string, _ *config.Config, _ bool, _ *rule.File, _, _, _ []string) {
+		viaWalkEx = append(viaWalkEx, rel)
+	})
+
+	if diff := cmp.Diff(viaWalk, viaWalkEx); diff != "" {
+		t.Errorf("WalkEx with a nil pre (-Walk +WalkEx):\n%s", diff)
+	}
+}